@@ -1,24 +1,116 @@
 package main
 
 import (
+	"fmt"
 	"goatrobotics/services"
 	"net/http"
+	"os"
+	"strconv"
 
+	"github.com/gorilla/mux"
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 )
 
+// defaultRoom is created at startup so the server is usable without first
+// calling POST /rooms.
+const defaultRoom = "general"
+
+// maxMessageLength bounds how long a single chat message can be.
+const maxMessageLength = 2000
+
+// defaultMaxRooms bounds how many rooms the Hub will create when
+// CHAT_MAX_ROOMS isn't set, so an unauthenticated caller can't exhaust
+// server memory by creating rooms in a loop.
+const defaultMaxRooms = 1000
+
 func main() {
 	loggy := logrus.New()
-	chatRoom := services.NewChatRoom(loggy)
-	go chatRoom.Run()
 
-	http.HandleFunc("/join", chatRoom.HandleJoin)
-	http.HandleFunc("/leave", chatRoom.HandleLeave)
-	http.HandleFunc("/send", chatRoom.HandleSend)
-	http.HandleFunc("/messages", chatRoom.HandleMessages)
+	nodeID, err := os.Hostname()
+	if err != nil {
+		nodeID = "chat-server"
+	}
+
+	opts := []services.HubOption{
+		services.WithHubValidators(
+			services.MaxLengthValidator{Max: maxMessageLength},
+			services.UTF8Validator{},
+		),
+	}
+	if secret := os.Getenv("CHAT_AUTH_SECRET"); secret != "" {
+		opts = append(opts, services.WithHubAuth(services.SharedSecretAuth{Secret: secret}))
+	}
+	if factory := storeFactoryFromEnv(); factory != nil {
+		opts = append(opts, services.WithHubStoreFactory(factory))
+	}
+	if factory := brokerFactoryFromEnv(loggy); factory != nil {
+		opts = append(opts, services.WithHubBrokerFactory(factory))
+	}
+	opts = append(opts, services.WithHubMaxRooms(maxRoomsFromEnv(loggy)))
+
+	hub := services.NewHub(loggy, nodeID, opts...)
+	if _, err := hub.CreateRoom(defaultRoom, services.RoomConfig{}); err != nil {
+		loggy.Fatal("failed to create default room", err)
+	}
+
+	router := mux.NewRouter()
+	hub.Register(router)
 
 	loggy.Info("Starting chat server on :8080...")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	if err := http.ListenAndServe(":8080", router); err != nil {
 		loggy.Fatal("Server error:", err)
 	}
 }
+
+// storeFactoryFromEnv returns a StoreFactory backed by Redis when
+// CHAT_STORE_BACKEND=redis, or nil to leave the Hub's default MemoryStore
+// in place.
+func storeFactoryFromEnv() services.StoreFactory {
+	if os.Getenv("CHAT_STORE_BACKEND") != "redis" {
+		return nil
+	}
+	client := redis.NewClient(&redis.Options{Addr: os.Getenv("CHAT_REDIS_ADDR")})
+	return func(name string, historyLength int) services.MessageStore {
+		return services.NewRedisStore(client, fmt.Sprintf("chat:%s", name), int64(historyLength))
+	}
+}
+
+// maxRoomsFromEnv returns defaultMaxRooms, or the value of CHAT_MAX_ROOMS
+// when it's set to a valid non-negative integer.
+func maxRoomsFromEnv(loggy *logrus.Logger) int {
+	raw := os.Getenv("CHAT_MAX_ROOMS")
+	if raw == "" {
+		return defaultMaxRooms
+	}
+	maxRooms, err := strconv.Atoi(raw)
+	if err != nil || maxRooms < 0 {
+		loggy.Error("invalid CHAT_MAX_ROOMS, falling back to default", raw)
+		return defaultMaxRooms
+	}
+	return maxRooms
+}
+
+// brokerFactoryFromEnv returns a BrokerFactory backed by NATS or Redis
+// Pub/Sub per CHAT_BROKER_BACKEND, or nil to leave the Hub's default
+// LocalBroker in place for single-node deployments.
+func brokerFactoryFromEnv(loggy *logrus.Logger) services.BrokerFactory {
+	switch os.Getenv("CHAT_BROKER_BACKEND") {
+	case "nats":
+		conn, err := nats.Connect(os.Getenv("CHAT_NATS_URL"))
+		if err != nil {
+			loggy.Fatal("failed to connect to NATS", err)
+		}
+		return func(name string) services.Broker {
+			return services.NewNATSBroker(conn, fmt.Sprintf("chat.%s", name))
+		}
+	case "redis":
+		client := redis.NewClient(&redis.Options{Addr: os.Getenv("CHAT_REDIS_ADDR")})
+		return func(name string) services.Broker {
+			return services.NewRedisBroker(client, fmt.Sprintf("chat:%s", name))
+		}
+	default:
+		return nil
+	}
+}
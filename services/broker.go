@@ -0,0 +1,45 @@
+package services
+
+import "context"
+
+// Broker fans broadcast messages out across chat-server nodes so that
+// multiple processes behind a load balancer can serve the same room.
+// ChatRoom.Run publishes every local broadcast to the broker and relays
+// whatever the broker delivers back out to local clients, so a
+// single-node deployment and a clustered one share the same code path.
+type Broker interface {
+	Publish(ctx context.Context, msg Message) error
+	Subscribe(ctx context.Context) (<-chan Message, error)
+	Close() error
+}
+
+// LocalBroker is a no-op Broker for single-node deployments: it loops
+// published messages straight back to its own subscriber in memory, with
+// no network hop.
+type LocalBroker struct {
+	messages chan Message
+}
+
+// NewLocalBroker creates a LocalBroker with a reasonably sized internal
+// buffer so a burst of sends doesn't block HandleSend.
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{messages: make(chan Message, 256)}
+}
+
+func (b *LocalBroker) Publish(ctx context.Context, msg Message) error {
+	select {
+	case b.messages <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *LocalBroker) Subscribe(ctx context.Context) (<-chan Message, error) {
+	return b.messages, nil
+}
+
+func (b *LocalBroker) Close() error {
+	close(b.messages)
+	return nil
+}
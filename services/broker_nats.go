@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSBroker fans messages out over a NATS subject, one subject per room.
+type NATSBroker struct {
+	conn    *nats.Conn
+	subject string
+	sub     *nats.Subscription
+}
+
+// NewNATSBroker creates a NATSBroker that publishes and subscribes on the
+// given subject, e.g. "chat.<room>".
+func NewNATSBroker(conn *nats.Conn, subject string) *NATSBroker {
+	return &NATSBroker{conn: conn, subject: subject}
+}
+
+func (b *NATSBroker) Publish(ctx context.Context, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return b.conn.Publish(b.subject, data)
+}
+
+func (b *NATSBroker) Subscribe(ctx context.Context) (<-chan Message, error) {
+	natsMsgs := make(chan *nats.Msg, 64)
+	sub, err := b.conn.ChanSubscribe(b.subject, natsMsgs)
+	if err != nil {
+		return nil, err
+	}
+	b.sub = sub
+
+	out := make(chan Message, 64)
+	go func() {
+		defer close(out)
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case natsMsg, ok := <-natsMsgs:
+				if !ok {
+					return
+				}
+				var msg Message
+				if err := json.Unmarshal(natsMsg.Data, &msg); err != nil {
+					continue
+				}
+				out <- msg
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// Close unsubscribes this room's subject, leaving the shared *nats.Conn
+// (handed to every room's NATSBroker) open for the rest of the node.
+func (b *NATSBroker) Close() error {
+	if b.sub != nil {
+		return b.sub.Unsubscribe()
+	}
+	return nil
+}
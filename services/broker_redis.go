@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBroker fans messages out over a Redis Pub/Sub channel, one channel
+// per room.
+type RedisBroker struct {
+	client  *redis.Client
+	channel string
+	pubsub  *redis.PubSub
+}
+
+// NewRedisBroker creates a RedisBroker that publishes and subscribes on
+// the given channel name.
+func NewRedisBroker(client *redis.Client, channel string) *RedisBroker {
+	return &RedisBroker{client: client, channel: channel}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, b.channel, data).Err()
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context) (<-chan Message, error) {
+	b.pubsub = b.client.Subscribe(ctx, b.channel)
+	redisMsgs := b.pubsub.Channel()
+
+	out := make(chan Message, 64)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case redisMsg, ok := <-redisMsgs:
+				if !ok {
+					return
+				}
+				var msg Message
+				if err := json.Unmarshal([]byte(redisMsg.Payload), &msg); err != nil {
+					continue
+				}
+				out <- msg
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (b *RedisBroker) Close() error {
+	if b.pubsub != nil {
+		return b.pubsub.Close()
+	}
+	return nil
+}
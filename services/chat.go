@@ -1,83 +1,398 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+// historyReplayCount is how many past messages a newly joined client is
+// caught up with from the MessageStore.
+const historyReplayCount = 50
+
+// SlowConsumerPolicy decides what happens when a client's Messages buffer
+// is full and a new message arrives for it.
+type SlowConsumerPolicy int
+
+const (
+	// PolicyDropOldest discards the client's oldest buffered message to
+	// make room, and marks the client Lagging so it can catch up from the
+	// store using its LastAcked seq.
+	PolicyDropOldest SlowConsumerPolicy = iota
+	// PolicyDisconnect removes the client from the room outright.
+	PolicyDisconnect
+	// PolicyBackpressure never drops: each client's messages are queued
+	// and delivered in order as it drains its buffer. The queue is
+	// unbounded, and one slow client does not hold up the others.
+	PolicyBackpressure
+)
+
 // chat message
 type Message struct {
+	ID       string
 	SenderID string
 	Content  string
 	Time     time.Time
+	// Seq is a monotonically increasing, room-local sequence number
+	// clients ack against to track their delivery position.
+	Seq uint64
 }
 
 // chat client
 type Client struct {
 	ID       string
 	Messages chan Message
+	// LastAcked is the highest Seq the client has acked via /ack.
+	LastAcked uint64
+	// Lagging is set when the client's buffer overflowed under
+	// PolicyDropOldest; HandleMessages resumes it from the store instead
+	// of waiting on Messages until it has caught up.
+	Lagging bool
+	// left is closed when the client leaves, so its backpressureWorker
+	// (if any) stops instead of blocking forever on a client nobody will
+	// ever drain again.
+	left chan struct{}
+
+	// backpressureQueue and backpressureWake back PolicyBackpressure:
+	// deliverLocal appends to the queue and nudges wake, and this
+	// client's single backpressureWorker drains the queue in order, so
+	// messages to one slow client are never reordered or fanned out
+	// across multiple concurrent senders.
+	backpressureQueue []Message
+	backpressureWake  chan struct{}
 }
 
 // ChatRoom for chat state and operations
 type ChatRoom struct {
-	clients   map[string]*Client
-	broadcast chan Message
-	join      chan *Client
-	leave     chan string
-	mutex     sync.RWMutex
-	loggy     *logrus.Logger
+	clients       map[string]*Client
+	broadcast     chan Message
+	join          chan *Client
+	leave         chan string
+	mutex         sync.RWMutex
+	loggy         *logrus.Logger
+	store         MessageStore
+	broker        Broker
+	nodeID        string
+	ctx           context.Context
+	cancel        context.CancelFunc
+	done          chan struct{}
+	maxClients    int
+	seq           uint64
+	slowPolicy    SlowConsumerPolicy
+	auth          Auth
+	limiter       *perClientLimiter
+	preprocessors []MessagePreprocessor
+	validators    []MessageValidator
+}
+
+// ChatRoomOption configures optional ChatRoom behavior in NewChatRoom.
+type ChatRoomOption func(*ChatRoom)
+
+// WithMaxClients caps concurrent membership; 0 (the default) is unlimited.
+func WithMaxClients(n int) ChatRoomOption {
+	return func(cr *ChatRoom) { cr.maxClients = n }
+}
+
+// WithSlowConsumerPolicy selects what happens to a client whose buffer
+// can't keep up with the room. The default is PolicyDropOldest.
+func WithSlowConsumerPolicy(policy SlowConsumerPolicy) ChatRoomOption {
+	return func(cr *ChatRoom) { cr.slowPolicy = policy }
+}
+
+// WithAuth requires every handler to authenticate requests via auth
+// instead of trusting the "id" query parameter.
+func WithAuth(auth Auth) ChatRoomOption {
+	return func(cr *ChatRoom) { cr.auth = auth }
+}
+
+// WithPreprocessors sets the MessagePreprocessors run, in order, on a
+// message's content before validation and broadcast.
+func WithPreprocessors(preprocessors ...MessagePreprocessor) ChatRoomOption {
+	return func(cr *ChatRoom) { cr.preprocessors = preprocessors }
 }
 
-func NewChatRoom(logger *logrus.Logger) *ChatRoom {
-	return &ChatRoom{
+// WithValidators sets the MessageValidators run, in order, on a message's
+// content before it's broadcast.
+func WithValidators(validators ...MessageValidator) ChatRoomOption {
+	return func(cr *ChatRoom) { cr.validators = validators }
+}
+
+// NewChatRoom wires up a ChatRoom. broker may be a *LocalBroker for
+// single-node use, or a clustered implementation (NATS, Redis) to fan
+// broadcasts out to other chat-server nodes. nodeID identifies this node
+// in logs. See the With* functions for optional behavior.
+func NewChatRoom(logger *logrus.Logger, store MessageStore, broker Broker, nodeID string, opts ...ChatRoomOption) *ChatRoom {
+	ctx, cancel := context.WithCancel(context.Background())
+	cr := &ChatRoom{
 		clients:   make(map[string]*Client),
 		broadcast: make(chan Message),
 		join:      make(chan *Client),
 		leave:     make(chan string),
 		loggy:     logger,
+		store:     store,
+		broker:    broker,
+		nodeID:    nodeID,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		limiter:   newPerClientLimiter(),
+	}
+	for _, opt := range opts {
+		opt(cr)
+	}
+	return cr
+}
+
+// authenticate resolves the caller's client ID. With no Auth configured it
+// falls back to the historical, trusted "id" query parameter.
+func (cr *ChatRoom) authenticate(r *http.Request) (string, error) {
+	if cr.auth == nil {
+		return r.URL.Query().Get("id"), nil
+	}
+	return cr.auth.Authenticate(r)
+}
+
+// writeJSONError writes a structured JSON error response with the given
+// status code.
+func (cr *ChatRoom) writeJSONError(w http.ResponseWriter, status int, message string) {
+	response, err := json.Marshal(map[string]interface{}{
+		"status":  "error",
+		"message": message,
+	})
+	if err != nil {
+		http.Error(w, message, status)
+		return
 	}
+	w.WriteHeader(status)
+	w.Write(response)
 }
 
+// Run is the single goroutine that owns room membership and delivery.
+// Local sends are persisted and published to the broker; the broker's own
+// subscription (which receives this node's publishes as well as any other
+// node's) is what actually fans messages out to local clients. That keeps
+// single-node and multi-node deployments on one delivery path.
 func (cr *ChatRoom) Run() {
+	defer close(cr.done)
+
+	remote, err := cr.broker.Subscribe(cr.ctx)
+	if err != nil {
+		cr.loggy.Fatal("failed to subscribe to broker", err)
+	}
+
 	for {
 		select {
 		case client := <-cr.join:
 			cr.mutex.Lock()
 			cr.clients[client.ID] = client
+			if cr.slowPolicy == PolicyBackpressure {
+				client.backpressureWake = make(chan struct{}, 1)
+				go cr.backpressureWorker(cr.ctx, client)
+			}
 			cr.mutex.Unlock()
 			cr.loggy.Info("Client joined the chat", client.ID)
+			cr.replayHistory(client)
 
 		case clientID := <-cr.leave:
 			cr.mutex.Lock()
 			if client, exists := cr.clients[clientID]; exists {
 				close(client.Messages)
+				close(client.left)
 				delete(cr.clients, clientID)
 			}
 			cr.mutex.Unlock()
+			cr.limiter.forget(clientID)
 			cr.loggy.Info("Client left the chat", clientID)
 
 		case msg := <-cr.broadcast:
-			cr.mutex.RLock()
-			for _, client := range cr.clients {
-				// Use non-blocking send to prevent deadlock if client buffer is full
-				select {
-				case client.Messages <- msg:
-				default:
-					// Message dropped if client's buffer is full
-					cr.loggy.Info("client buffer is full")
+			cr.seq++
+			msg.Seq = cr.seq
+
+			if cr.store != nil {
+				if id, err := cr.store.Append(msg); err != nil {
+					cr.loggy.Error("failed to persist message", err)
+				} else {
+					msg.ID = id
 				}
 			}
-			cr.mutex.RUnlock()
+
+			if err := cr.broker.Publish(cr.ctx, msg); err != nil {
+				cr.loggy.Error("failed to publish message to broker", cr.nodeID, err)
+			}
+
+		case msg, ok := <-remote:
+			if !ok {
+				return
+			}
+			cr.deliverLocal(msg)
+
+		case <-cr.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliverLocal fans a message (whether published by this node or received
+// from another) out to every client currently connected to this node,
+// applying the room's SlowConsumerPolicy to anyone whose buffer is full.
+// PolicyBackpressure always enqueues here, even when the buffer has room,
+// so no message overtakes one still queued; the per-client
+// backpressureWorker does the actual blocking send, so one slow client
+// stalls only its own delivery instead of the room's entire select loop.
+func (cr *ChatRoom) deliverLocal(msg Message) {
+	cr.mutex.Lock()
+	defer cr.mutex.Unlock()
+
+	for id, client := range cr.clients {
+		if cr.slowPolicy != PolicyBackpressure {
+			select {
+			case client.Messages <- msg:
+				continue
+			default:
+			}
+		}
+
+		switch cr.slowPolicy {
+		case PolicyDisconnect:
+			cr.loggy.Info("disconnecting lagging client", id)
+			close(client.Messages)
+			close(client.left)
+			delete(cr.clients, id)
+			cr.limiter.forget(id)
+
+		case PolicyBackpressure:
+			client.backpressureQueue = append(client.backpressureQueue, msg)
+			select {
+			case client.backpressureWake <- struct{}{}:
+			default:
+			}
+
+		default: // PolicyDropOldest
+			select {
+			case <-client.Messages:
+			default:
+			}
+			select {
+			case client.Messages <- msg:
+			default:
+			}
+			client.Lagging = true
+			cr.loggy.Info("client lagging, dropped oldest buffered message", id)
+		}
+	}
+}
+
+// backpressureWorker is the single goroutine that drains a
+// PolicyBackpressure client's backpressureQueue, oldest first, blocking
+// on Messages as needed. Keeping exactly one worker per client (started
+// on join, stopped on leave or room shutdown) means queued messages are
+// always delivered in order and a stalled client never piles up more
+// than one extra goroutine.
+func (cr *ChatRoom) backpressureWorker(ctx context.Context, client *Client) {
+	for {
+		cr.mutex.Lock()
+		if len(client.backpressureQueue) == 0 {
+			cr.mutex.Unlock()
+			select {
+			case <-client.backpressureWake:
+				continue
+			case <-client.left:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+		msg := client.backpressureQueue[0]
+		client.backpressureQueue = client.backpressureQueue[1:]
+		cr.mutex.Unlock()
+
+		if !cr.sendBackpressured(ctx, client, msg) {
+			return
+		}
+	}
+}
+
+// sendBackpressured blocks until client's buffer has room for msg, the
+// room shuts down, or the client leaves, returning false in the latter
+// two cases so backpressureWorker stops. Leaving closes client.Messages
+// out from under this blocked send, which panics a closed-channel send
+// instead of returning an error, so we recover rather than crash the
+// process over a client that's already gone.
+func (cr *ChatRoom) sendBackpressured(ctx context.Context, client *Client, msg Message) (delivered bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			cr.loggy.Info("backpressured client left before delivery", client.ID)
+			delivered = false
+		}
+	}()
+
+	select {
+	case client.Messages <- msg:
+		return true
+	case <-client.left:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Close stops Run and releases the broker's subscription. It waits for
+// Run to actually exit before closing the broker, since closing it out
+// from under a still-running Run would turn its next Publish or remote
+// read into a send on (or a read from) a closed channel.
+func (cr *ChatRoom) Close() error {
+	cr.cancel()
+	<-cr.done
+	return cr.broker.Close()
+}
+
+// replayHistory catches a newly joined client up on recent messages from
+// the store so it doesn't miss anything that happened before it connected.
+func (cr *ChatRoom) replayHistory(client *Client) {
+	if cr.store == nil {
+		return
+	}
+
+	// Page forward through the store, keeping only the last
+	// historyReplayCount messages seen.
+	var history []Message
+	for cursor := ""; ; {
+		page, err := cr.store.Range(cursor, historyReplayCount)
+		if err != nil {
+			cr.loggy.Error("failed to load message history", err)
+			return
+		}
+		history = append(history, page...)
+		if len(history) > historyReplayCount {
+			history = history[len(history)-historyReplayCount:]
+		}
+		if len(page) < historyReplayCount {
+			break
+		}
+		cursor = page[len(page)-1].ID
+	}
+
+	for _, msg := range history {
+		select {
+		case client.Messages <- msg:
+		default:
+			cr.loggy.Info("client buffer is full during history replay", client.ID)
 		}
 	}
 }
 
 func (cr *ChatRoom) HandleJoin(w http.ResponseWriter, r *http.Request) {
-	clientID := r.URL.Query().Get("id")
+	clientID, err := cr.authenticate(r)
+	if err != nil {
+		cr.writeJSONError(w, http.StatusUnauthorized, "authentication failed")
+		cr.loggy.Error("authentication failed", err)
+		return
+	}
 	if clientID == "" {
 		http.Error(w, "Client ID is required", http.StatusBadRequest)
 		cr.loggy.Error("Client ID is required")
@@ -85,17 +400,24 @@ func (cr *ChatRoom) HandleJoin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	cr.mutex.RLock()
-	if _, exists := cr.clients[clientID]; exists {
-		cr.mutex.RUnlock()
+	_, exists := cr.clients[clientID]
+	atCapacity := cr.maxClients > 0 && len(cr.clients) >= cr.maxClients
+	cr.mutex.RUnlock()
+	if exists {
 		http.Error(w, "Client ID already exists", http.StatusConflict)
 		cr.loggy.Error("Client ID already exists")
 		return
 	}
-	cr.mutex.RUnlock()
+	if atCapacity {
+		http.Error(w, "Room is full", http.StatusServiceUnavailable)
+		cr.loggy.Error("room is at capacity", cr.maxClients)
+		return
+	}
 
 	client := &Client{
 		ID:       clientID,
 		Messages: make(chan Message, 100), // Buffer size of 100 messages
+		left:     make(chan struct{}),
 	}
 
 	cr.join <- client
@@ -114,7 +436,12 @@ func (cr *ChatRoom) HandleJoin(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cr *ChatRoom) HandleLeave(w http.ResponseWriter, r *http.Request) {
-	clientID := r.URL.Query().Get("id")
+	clientID, err := cr.authenticate(r)
+	if err != nil {
+		cr.writeJSONError(w, http.StatusUnauthorized, "authentication failed")
+		cr.loggy.Error("authentication failed", err)
+		return
+	}
 	if clientID == "" {
 		http.Error(w, "Client ID is required", http.StatusBadRequest)
 		cr.loggy.Error("Client ID is required")
@@ -146,7 +473,12 @@ func (cr *ChatRoom) HandleLeave(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cr *ChatRoom) HandleSend(w http.ResponseWriter, r *http.Request) {
-	clientID := r.URL.Query().Get("id")
+	clientID, err := cr.authenticate(r)
+	if err != nil {
+		cr.writeJSONError(w, http.StatusUnauthorized, "authentication failed")
+		cr.loggy.Error("authentication failed", err)
+		return
+	}
 	message := r.URL.Query().Get("message")
 
 	if clientID == "" || message == "" {
@@ -164,6 +496,23 @@ func (cr *ChatRoom) HandleSend(w http.ResponseWriter, r *http.Request) {
 	}
 	cr.mutex.RUnlock()
 
+	if !cr.limiter.allow(clientID) {
+		cr.writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		cr.loggy.Error("rate limit exceeded", clientID)
+		return
+	}
+
+	for _, preprocessor := range cr.preprocessors {
+		message = preprocessor.Apply(message)
+	}
+	for _, validator := range cr.validators {
+		if err := validator.Validate(message); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			cr.loggy.Error("message validation failed", err)
+			return
+		}
+	}
+
 	cr.broadcast <- Message{
 		SenderID: clientID,
 		Content:  message,
@@ -186,7 +535,12 @@ func (cr *ChatRoom) HandleSend(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cr *ChatRoom) HandleMessages(w http.ResponseWriter, r *http.Request) {
-	clientID := r.URL.Query().Get("id")
+	clientID, err := cr.authenticate(r)
+	if err != nil {
+		cr.writeJSONError(w, http.StatusUnauthorized, "authentication failed")
+		cr.loggy.Error("authentication failed", err)
+		return
+	}
 	if clientID == "" {
 		http.Error(w, "Client ID is required", http.StatusBadRequest)
 		cr.loggy.Error("Client ID is required", clientID)
@@ -203,6 +557,29 @@ func (cr *ChatRoom) HandleMessages(w http.ResponseWriter, r *http.Request) {
 	}
 	cr.mutex.RUnlock()
 
+	if since := r.URL.Query().Get("since"); since != "" && cr.store != nil {
+		history, err := cr.store.Range(since, 1)
+		if err != nil {
+			cr.loggy.Error("failed to load message history", err)
+			http.Error(w, "error in reading history, please retry", http.StatusInternalServerError)
+			return
+		}
+		if len(history) > 0 {
+			cr.writeMessage(w, history[0])
+			return
+		}
+	}
+
+	cr.mutex.RLock()
+	lagging := client.Lagging
+	cr.mutex.RUnlock()
+	if lagging {
+		if msg, ok := cr.resumeLagging(client); ok {
+			cr.writeMessage(w, msg)
+			return
+		}
+	}
+
 	select {
 	case msg, ok := <-client.Messages:
 		if !ok {
@@ -211,18 +588,7 @@ func (cr *ChatRoom) HandleMessages(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		cr.loggy.Info(msg.SenderID, msg.Content)
-		response, err := json.Marshal(map[string]interface{}{
-			"status":  "success",
-			"client":  msg.SenderID,
-			"message": msg.Content,
-		})
-		if err != nil {
-			cr.loggy.Error("error in formatting response")
-			http.Error(w, "error in parsing response, let's try again", http.StatusInternalServerError)
-			return
-		}
-		w.WriteHeader(http.StatusOK)
-		w.Write(response)
+		cr.writeMessage(w, msg)
 	case <-time.After(30 * time.Second):
 		cr.loggy.Info("no new messages")
 		cr.loggy.Info("message sent")
@@ -238,3 +604,112 @@ func (cr *ChatRoom) HandleMessages(w http.ResponseWriter, r *http.Request) {
 		w.Write(response)
 	}
 }
+
+// resumeLagging looks for the oldest still-unacked message (Seq greater
+// than the client's LastAcked) in the store, for a client that got marked
+// Lagging after a buffer overflow. It clears Lagging once the client has
+// caught all the way up.
+func (cr *ChatRoom) resumeLagging(client *Client) (Message, bool) {
+	if cr.store == nil {
+		cr.mutex.Lock()
+		client.Lagging = false
+		cr.mutex.Unlock()
+		return Message{}, false
+	}
+
+	cr.mutex.RLock()
+	lastAcked := client.LastAcked
+	cr.mutex.RUnlock()
+
+	for cursor := ""; ; {
+		history, err := cr.store.Range(cursor, historyReplayCount)
+		if err != nil {
+			cr.loggy.Error("failed to load message history for lagging client", client.ID, err)
+			return Message{}, false
+		}
+		for _, msg := range history {
+			if msg.Seq > lastAcked {
+				return msg, true
+			}
+		}
+		if len(history) < historyReplayCount {
+			break
+		}
+		cursor = history[len(history)-1].ID
+	}
+
+	cr.mutex.Lock()
+	client.Lagging = false
+	cr.mutex.Unlock()
+	return Message{}, false
+}
+
+// HandleAck records the highest Seq a client has processed, so a future
+// resumeLagging knows where to pick back up.
+func (cr *ChatRoom) HandleAck(w http.ResponseWriter, r *http.Request) {
+	clientID, err := cr.authenticate(r)
+	if err != nil {
+		cr.writeJSONError(w, http.StatusUnauthorized, "authentication failed")
+		cr.loggy.Error("authentication failed", err)
+		return
+	}
+	seqParam := r.URL.Query().Get("seq")
+	if clientID == "" || seqParam == "" {
+		http.Error(w, "id and seq are required", http.StatusBadRequest)
+		cr.loggy.Error("id and seq are required")
+		return
+	}
+
+	seq, err := strconv.ParseUint(seqParam, 10, 64)
+	if err != nil {
+		http.Error(w, "seq must be a non-negative integer", http.StatusBadRequest)
+		cr.loggy.Error("invalid seq", seqParam)
+		return
+	}
+
+	cr.mutex.Lock()
+	client, exists := cr.clients[clientID]
+	if !exists {
+		cr.mutex.Unlock()
+		http.Error(w, "Client not found", http.StatusNotFound)
+		cr.loggy.Error("Client ID not found", clientID)
+		return
+	}
+	if seq > client.LastAcked {
+		client.LastAcked = seq
+	}
+	lastAcked := client.LastAcked
+	cr.mutex.Unlock()
+
+	response, err := json.Marshal(map[string]interface{}{
+		"status":    "success",
+		"lastAcked": lastAcked,
+	})
+	if err != nil {
+		cr.loggy.Error("error in formatting response")
+		http.Error(w, "error in parsing response, let's try again", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+// writeMessage writes msg as the standard success JSON response, including
+// its store-assigned ID and room seq so the client can resume from it
+// with ?since= or ack it with /ack.
+func (cr *ChatRoom) writeMessage(w http.ResponseWriter, msg Message) {
+	response, err := json.Marshal(map[string]interface{}{
+		"status":  "success",
+		"id":      msg.ID,
+		"seq":     msg.Seq,
+		"client":  msg.SenderID,
+		"message": msg.Content,
+	})
+	if err != nil {
+		cr.loggy.Error("error in formatting response")
+		http.Error(w, "error in parsing response, let's try again", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
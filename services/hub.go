@@ -0,0 +1,331 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultHistoryLength is used for a room created without an explicit
+// HistoryLength.
+const defaultHistoryLength = 1000
+
+// RoomConfig configures a room created through the Hub.
+type RoomConfig struct {
+	MaxClients    int                `json:"max_clients"`
+	HistoryLength int                `json:"history_length"`
+	TTL           time.Duration      `json:"ttl"`
+	SlowPolicy    SlowConsumerPolicy `json:"slow_policy"`
+}
+
+// StoreFactory builds the MessageStore for a room named name, given its
+// configured history length. The default factory returns a MemoryStore.
+type StoreFactory func(name string, historyLength int) MessageStore
+
+// BrokerFactory builds the Broker for a room named name. The default
+// factory returns a LocalBroker.
+type BrokerFactory func(name string) Broker
+
+// Hub manages many named ChatRooms, each running its own Run loop with
+// independent membership and history, so the server can host more than
+// one conversation at a time. Client buffer sizes are not set per room:
+// every client of every room gets a 100-message buffer.
+type Hub struct {
+	mutex         sync.RWMutex
+	rooms         map[string]*ChatRoom
+	loggy         *logrus.Logger
+	nodeID        string
+	auth          Auth
+	maxRooms      int
+	preprocessors []MessagePreprocessor
+	validators    []MessageValidator
+	storeFactory  StoreFactory
+	brokerFactory BrokerFactory
+}
+
+// HubOption configures optional Hub-wide behavior, applied to every room
+// the Hub creates.
+type HubOption func(*Hub)
+
+// WithHubAuth requires every room's handlers to authenticate requests via
+// auth instead of trusting the "id" query parameter.
+func WithHubAuth(auth Auth) HubOption {
+	return func(h *Hub) { h.auth = auth }
+}
+
+// WithHubPreprocessors sets the MessagePreprocessors every room runs on a
+// message's content before validation and broadcast.
+func WithHubPreprocessors(preprocessors ...MessagePreprocessor) HubOption {
+	return func(h *Hub) { h.preprocessors = preprocessors }
+}
+
+// WithHubValidators sets the MessageValidators every room runs on a
+// message's content before it's broadcast.
+func WithHubValidators(validators ...MessageValidator) HubOption {
+	return func(h *Hub) { h.validators = validators }
+}
+
+// WithHubStoreFactory overrides how every room's MessageStore is built,
+// e.g. to hand out RedisStores instead of the default MemoryStore.
+func WithHubStoreFactory(factory StoreFactory) HubOption {
+	return func(h *Hub) { h.storeFactory = factory }
+}
+
+// WithHubBrokerFactory overrides how every room's Broker is built, e.g.
+// to hand out NATSBrokers or RedisBrokers instead of the default
+// LocalBroker so rooms fan out across chat-server nodes.
+func WithHubBrokerFactory(factory BrokerFactory) HubOption {
+	return func(h *Hub) { h.brokerFactory = factory }
+}
+
+// WithHubMaxRooms caps how many rooms the Hub will create, so an
+// unauthenticated or misbehaving caller can't exhaust server memory by
+// POSTing /rooms in a loop. 0 (the default) leaves room creation
+// unbounded.
+func WithHubMaxRooms(maxRooms int) HubOption {
+	return func(h *Hub) { h.maxRooms = maxRooms }
+}
+
+// NewHub creates an empty Hub. Rooms are added via CreateRoom.
+func NewHub(logger *logrus.Logger, nodeID string, opts ...HubOption) *Hub {
+	h := &Hub{
+		rooms:  make(map[string]*ChatRoom),
+		loggy:  logger,
+		nodeID: nodeID,
+		storeFactory: func(name string, historyLength int) MessageStore {
+			return NewMemoryStore(historyLength)
+		},
+		brokerFactory: func(name string) Broker {
+			return NewLocalBroker()
+		},
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// CreateRoom creates and starts a room with the given name and config. It
+// errors if a room with that name already exists.
+func (h *Hub) CreateRoom(name string, config RoomConfig) (*ChatRoom, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, exists := h.rooms[name]; exists {
+		return nil, fmt.Errorf("room %q already exists", name)
+	}
+	if h.maxRooms > 0 && len(h.rooms) >= h.maxRooms {
+		return nil, fmt.Errorf("hub is at its room limit of %d", h.maxRooms)
+	}
+
+	historyLength := config.HistoryLength
+	if historyLength <= 0 {
+		historyLength = defaultHistoryLength
+	}
+
+	store := h.storeFactory(name, historyLength)
+	broker := h.brokerFactory(name)
+	room := NewChatRoom(h.loggy, store, broker, h.nodeID,
+		WithMaxClients(config.MaxClients),
+		WithSlowConsumerPolicy(config.SlowPolicy),
+		WithAuth(h.auth),
+		WithPreprocessors(h.preprocessors...),
+		WithValidators(h.validators...),
+	)
+	go room.Run()
+
+	h.rooms[name] = room
+
+	if config.TTL > 0 {
+		go h.expireAfter(name, config.TTL)
+	}
+
+	return room, nil
+}
+
+// expireAfter removes and closes the named room once its TTL elapses,
+// unless it was already removed.
+func (h *Hub) expireAfter(name string, ttl time.Duration) {
+	time.Sleep(ttl)
+	h.mutex.Lock()
+	room, exists := h.rooms[name]
+	if exists {
+		delete(h.rooms, name)
+	}
+	h.mutex.Unlock()
+
+	if exists {
+		h.loggy.Info("room expired", name)
+		room.Close()
+	}
+}
+
+// Room returns the named room, if it exists.
+func (h *Hub) Room(name string) (*ChatRoom, bool) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	room, exists := h.rooms[name]
+	return room, exists
+}
+
+// Names returns the names of all currently live rooms.
+func (h *Hub) Names() []string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	names := make([]string, 0, len(h.rooms))
+	for name := range h.rooms {
+		names = append(names, name)
+	}
+	return names
+}
+
+// authenticate resolves the caller's identity for Hub-level routes the
+// same way ChatRoom.authenticate does for per-room routes: with no Auth
+// configured it trusts the caller, otherwise it delegates to h.auth.
+func (h *Hub) authenticate(r *http.Request) (string, error) {
+	if h.auth == nil {
+		return r.URL.Query().Get("id"), nil
+	}
+	return h.auth.Authenticate(r)
+}
+
+// writeJSONError writes a structured JSON error response with the given
+// status code.
+func (h *Hub) writeJSONError(w http.ResponseWriter, status int, message string) {
+	response, err := json.Marshal(map[string]interface{}{
+		"status":  "error",
+		"message": message,
+	})
+	if err != nil {
+		http.Error(w, message, status)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+// HandleListRooms handles GET /rooms.
+func (h *Hub) HandleListRooms(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.authenticate(r); err != nil {
+		h.writeJSONError(w, http.StatusUnauthorized, "authentication failed")
+		h.loggy.Error("authentication failed", err)
+		return
+	}
+
+	response, err := json.Marshal(map[string]interface{}{
+		"status": "success",
+		"rooms":  h.Names(),
+	})
+	if err != nil {
+		h.loggy.Error("error in formatting response")
+		http.Error(w, "error in parsing response, let's try again", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(response)
+}
+
+type createRoomRequest struct {
+	Name          string `json:"name"`
+	MaxClients    int    `json:"max_clients"`
+	HistoryLength int    `json:"history_length"`
+	TTLSeconds    int    `json:"ttl_seconds"`
+	SlowPolicy    string `json:"slow_policy"`
+}
+
+// parseSlowPolicy maps the request's slow_policy string onto a
+// SlowConsumerPolicy, defaulting to PolicyDropOldest for an empty or
+// unrecognized value.
+func parseSlowPolicy(value string) SlowConsumerPolicy {
+	switch value {
+	case "disconnect":
+		return PolicyDisconnect
+	case "backpressure":
+		return PolicyBackpressure
+	default:
+		return PolicyDropOldest
+	}
+}
+
+// HandleCreateRoom handles POST /rooms.
+func (h *Hub) HandleCreateRoom(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.authenticate(r); err != nil {
+		h.writeJSONError(w, http.StatusUnauthorized, "authentication failed")
+		h.loggy.Error("authentication failed", err)
+		return
+	}
+
+	var req createRoomRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		h.loggy.Error("invalid create room request", err)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "room name is required", http.StatusBadRequest)
+		h.loggy.Error("room name is required")
+		return
+	}
+
+	config := RoomConfig{
+		MaxClients:    req.MaxClients,
+		HistoryLength: req.HistoryLength,
+		TTL:           time.Duration(req.TTLSeconds) * time.Second,
+		SlowPolicy:    parseSlowPolicy(req.SlowPolicy),
+	}
+
+	if _, err := h.CreateRoom(req.Name, config); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		h.loggy.Error("failed to create room", err)
+		return
+	}
+
+	h.loggy.Info("room created", req.Name)
+	response, err := json.Marshal(map[string]interface{}{
+		"status": "success",
+		"room":   req.Name,
+	})
+	if err != nil {
+		h.loggy.Error("error in formatting response")
+		http.Error(w, "error in parsing response, let's try again", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(response)
+}
+
+// roomHandler looks up the {room} path variable and delegates to next, or
+// responds 404 if the room doesn't exist.
+func (h *Hub) roomHandler(next func(cr *ChatRoom, w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["room"]
+		room, exists := h.Room(name)
+		if !exists {
+			http.Error(w, "room not found", http.StatusNotFound)
+			h.loggy.Error("room not found", name)
+			return
+		}
+		next(room, w, r)
+	}
+}
+
+// Register mounts the Hub's routes, including the per-room join/leave/
+// send/messages/ws/sse endpoints, on router.
+func (h *Hub) Register(router *mux.Router) {
+	router.HandleFunc("/rooms", h.HandleListRooms).Methods(http.MethodGet)
+	router.HandleFunc("/rooms", h.HandleCreateRoom).Methods(http.MethodPost)
+
+	router.HandleFunc("/rooms/{room}/join", h.roomHandler((*ChatRoom).HandleJoin))
+	router.HandleFunc("/rooms/{room}/leave", h.roomHandler((*ChatRoom).HandleLeave))
+	router.HandleFunc("/rooms/{room}/send", h.roomHandler((*ChatRoom).HandleSend))
+	router.HandleFunc("/rooms/{room}/messages", h.roomHandler((*ChatRoom).HandleMessages))
+	router.HandleFunc("/rooms/{room}/ack", h.roomHandler((*ChatRoom).HandleAck))
+	router.HandleFunc("/rooms/{room}/ws", h.roomHandler((*ChatRoom).HandleWS))
+	router.HandleFunc("/rooms/{room}/sse", h.roomHandler((*ChatRoom).HandleSSE))
+}
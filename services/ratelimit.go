@@ -0,0 +1,45 @@
+package services
+
+import (
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// sendRateLimit and sendRateBurst bound how fast a single client can
+	// post via HandleSend: 5 messages/sec sustained, bursting to 20.
+	sendRateLimit = 5
+	sendRateBurst = 20
+)
+
+// perClientLimiter hands out a token-bucket rate.Limiter per client ID,
+// creating one lazily on first use.
+type perClientLimiter struct {
+	mutex    sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newPerClientLimiter() *perClientLimiter {
+	return &perClientLimiter{limiters: make(map[string]*rate.Limiter)}
+}
+
+func (p *perClientLimiter) allow(clientID string) bool {
+	p.mutex.Lock()
+	limiter, exists := p.limiters[clientID]
+	if !exists {
+		limiter = rate.NewLimiter(rate.Limit(sendRateLimit), sendRateBurst)
+		p.limiters[clientID] = limiter
+	}
+	p.mutex.Unlock()
+
+	return limiter.Allow()
+}
+
+// forget drops clientID's limiter, e.g. once the client has left the room,
+// so the map doesn't grow forever as distinct clients churn through.
+func (p *perClientLimiter) forget(clientID string) {
+	p.mutex.Lock()
+	delete(p.limiters, clientID)
+	p.mutex.Unlock()
+}
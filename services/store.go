@@ -0,0 +1,122 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MessageStore persists broadcast messages so clients can replay history
+// on join and resume a feed after a disconnect instead of losing whatever
+// was sent while they were away.
+type MessageStore interface {
+	// Append records msg and returns the ID assigned to it. IDs are
+	// monotonically increasing within a store so callers can use the last
+	// seen ID as the `since` cursor for Range.
+	Append(msg Message) (id string, err error)
+
+	// Range returns up to count messages after fromID, oldest first. An
+	// empty fromID means "from the beginning".
+	Range(fromID string, count int) ([]Message, error)
+}
+
+// MemoryStore is an in-memory ring buffer MessageStore. It's the default
+// for single-node deployments and in tests; history does not survive a
+// restart.
+type MemoryStore struct {
+	mutex   sync.RWMutex
+	entries []storedMessage
+	size    int
+	seq     uint64
+}
+
+type storedMessage struct {
+	id  string
+	msg Message
+}
+
+// NewMemoryStore creates a MemoryStore that retains at most size messages.
+func NewMemoryStore(size int) *MemoryStore {
+	return &MemoryStore{size: size}
+}
+
+func (s *MemoryStore) Append(msg Message) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.seq++
+	id := fmt.Sprintf("%d-%d", time.Now().UnixMilli(), s.seq)
+	msg.ID = id
+
+	s.entries = append(s.entries, storedMessage{id: id, msg: msg})
+	if len(s.entries) > s.size {
+		s.entries = s.entries[len(s.entries)-s.size:]
+	}
+	return id, nil
+}
+
+func (s *MemoryStore) Range(fromID string, count int) ([]Message, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	start := 0
+	if fromID != "" {
+		start = len(s.entries)
+		for i, e := range s.entries {
+			if idLess(fromID, e.id) {
+				start = i
+				break
+			}
+		}
+	}
+
+	end := start + count
+	if end > len(s.entries) || count <= 0 {
+		end = len(s.entries)
+	}
+	if start >= end {
+		return nil, nil
+	}
+
+	out := make([]Message, 0, end-start)
+	for _, e := range s.entries[start:end] {
+		out = append(out, e.msg)
+	}
+	return out, nil
+}
+
+// parseEntryID splits a MemoryStore ID of the form "<millis>-<seq>" into
+// its numeric parts.
+func parseEntryID(id string) (millis int64, seq uint64, ok bool) {
+	prefix, suffix, found := strings.Cut(id, "-")
+	if !found {
+		return 0, 0, false
+	}
+	millis, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	seq, err = strconv.ParseUint(suffix, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return millis, seq, true
+}
+
+// idLess reports whether a sorts before b. IDs share the "<millis>-<seq>"
+// shape, so a plain string compare breaks as soon as seq crosses a
+// digit-width boundary (e.g. "...-9" vs "...-10"); compare the two parts
+// numerically instead.
+func idLess(a, b string) bool {
+	aMillis, aSeq, aOK := parseEntryID(a)
+	bMillis, bSeq, bOK := parseEntryID(b)
+	if !aOK || !bOK {
+		return a < b
+	}
+	if aMillis != bMillis {
+		return aMillis < bMillis
+	}
+	return aSeq < bSeq
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore is a MessageStore backed by a Redis Stream, suitable for
+// deployments that want history to survive a restart or be shared across
+// multiple chat-server processes. IDs are the stream entry IDs Redis
+// assigns (`<millis>-<seq>`), so they sort and compare the same way the
+// MemoryStore's IDs do.
+type RedisStore struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+// NewRedisStore creates a RedisStore that appends to the given stream key,
+// trimming it to approximately maxLen entries.
+func NewRedisStore(client *redis.Client, stream string, maxLen int64) *RedisStore {
+	return &RedisStore{client: client, stream: stream, maxLen: maxLen}
+}
+
+func (s *RedisStore) Append(msg Message) (string, error) {
+	ctx := context.Background()
+	id, err := s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		MaxLen: s.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"sender":  msg.SenderID,
+			"content": msg.Content,
+			"time":    msg.Time.UnixNano(),
+			"seq":     msg.Seq,
+		},
+	}).Result()
+	return id, err
+}
+
+func (s *RedisStore) Range(fromID string, count int) ([]Message, error) {
+	ctx := context.Background()
+
+	start := "-"
+	if fromID != "" {
+		start = "(" + fromID // exclusive range, don't repeat the cursor entry
+	}
+
+	entries, err := s.client.XRangeN(ctx, s.stream, start, "+", int64(count)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Message, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, entryToMessage(e))
+	}
+	return out, nil
+}
+
+func entryToMessage(e redis.XMessage) Message {
+	msg := Message{ID: e.ID}
+	if sender, ok := e.Values["sender"].(string); ok {
+		msg.SenderID = sender
+	}
+	if content, ok := e.Values["content"].(string); ok {
+		msg.Content = content
+	}
+	if raw, ok := e.Values["time"].(string); ok {
+		if nanos, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			msg.Time = time.Unix(0, nanos)
+		}
+	}
+	if raw, ok := e.Values["seq"].(string); ok {
+		if seq, err := strconv.ParseUint(raw, 10, 64); err == nil {
+			msg.Seq = seq
+		}
+	}
+	return msg
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait is the time allowed to write a message to the peer.
+	writeWait = 10 * time.Second
+
+	// pongWait is the time allowed to read the next pong message from the peer.
+	pongWait = 60 * time.Second
+
+	// pingPeriod sends pings to the peer with this period. Must be less than pongWait.
+	pingPeriod = (pongWait * 9) / 10
+
+	// sseKeepAlive is how often a comment is written to an SSE stream to keep
+	// intermediaries from closing the connection.
+	sseKeepAlive = 15 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// HandleWS upgrades the connection to a WebSocket and streams Message values
+// to the client as they are broadcast. Membership still flows through
+// ChatRoom.Run via the join/leave channels, so the room's client map stays
+// the single source of truth.
+func (cr *ChatRoom) HandleWS(w http.ResponseWriter, r *http.Request) {
+	clientID, err := cr.authenticate(r)
+	if err != nil {
+		cr.writeJSONError(w, http.StatusUnauthorized, "authentication failed")
+		cr.loggy.Error("authentication failed", err)
+		return
+	}
+	if clientID == "" {
+		http.Error(w, "Client ID is required", http.StatusBadRequest)
+		cr.loggy.Error("Client ID is required")
+		return
+	}
+
+	cr.mutex.RLock()
+	_, exists := cr.clients[clientID]
+	atCapacity := cr.maxClients > 0 && len(cr.clients) >= cr.maxClients
+	cr.mutex.RUnlock()
+	if exists {
+		http.Error(w, "Client ID already exists", http.StatusConflict)
+		cr.loggy.Error("Client ID already exists")
+		return
+	}
+	if atCapacity {
+		http.Error(w, "Room is full", http.StatusServiceUnavailable)
+		cr.loggy.Error("room is at capacity", cr.maxClients)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		cr.loggy.Error("failed to upgrade connection", err)
+		return
+	}
+
+	client := &Client{
+		ID:       clientID,
+		Messages: make(chan Message, 100), // Buffer size of 100 messages
+		left:     make(chan struct{}),
+	}
+	cr.join <- client
+	cr.loggy.Info("joined the chat over ws", clientID)
+
+	done := make(chan struct{})
+	go cr.wsWritePump(conn, client, done)
+	cr.wsReadPump(conn, clientID, done)
+}
+
+// wsReadPump keeps the connection alive and waits for the client (or the
+// network) to go away. We don't expect inbound chat traffic on this
+// connection, just pong frames and the close handshake.
+func (cr *ChatRoom) wsReadPump(conn *websocket.Conn, clientID string, done chan struct{}) {
+	defer func() {
+		close(done)
+		cr.leave <- clientID
+		conn.Close()
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			cr.loggy.Info("ws client disconnected", clientID)
+			return
+		}
+	}
+}
+
+// wsWritePump pushes broadcast messages to the client and pings it on a
+// schedule, bailing out once wsReadPump signals the connection is done.
+func (cr *ChatRoom) wsWritePump(conn *websocket.Conn, client *Client, done chan struct{}) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-client.Messages:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := conn.WriteJSON(msg); err != nil {
+				cr.loggy.Error("ws write failed", client.ID, err)
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// HandleSSE streams Message values to the client as a Server-Sent Events
+// feed, joining and leaving the room the same way HandleWS does.
+func (cr *ChatRoom) HandleSSE(w http.ResponseWriter, r *http.Request) {
+	clientID, err := cr.authenticate(r)
+	if err != nil {
+		cr.writeJSONError(w, http.StatusUnauthorized, "authentication failed")
+		cr.loggy.Error("authentication failed", err)
+		return
+	}
+	if clientID == "" {
+		http.Error(w, "Client ID is required", http.StatusBadRequest)
+		cr.loggy.Error("Client ID is required")
+		return
+	}
+
+	cr.mutex.RLock()
+	_, exists := cr.clients[clientID]
+	atCapacity := cr.maxClients > 0 && len(cr.clients) >= cr.maxClients
+	cr.mutex.RUnlock()
+	if exists {
+		http.Error(w, "Client ID already exists", http.StatusConflict)
+		cr.loggy.Error("Client ID already exists")
+		return
+	}
+	if atCapacity {
+		http.Error(w, "Room is full", http.StatusServiceUnavailable)
+		cr.loggy.Error("room is at capacity", cr.maxClients)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		cr.loggy.Error("response writer does not support flushing")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	client := &Client{
+		ID:       clientID,
+		Messages: make(chan Message, 100), // Buffer size of 100 messages
+		left:     make(chan struct{}),
+	}
+	cr.join <- client
+	cr.loggy.Info("joined the chat over sse", clientID)
+	defer func() {
+		cr.leave <- clientID
+		cr.loggy.Info("left the chat over sse", clientID)
+	}()
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-client.Messages:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(map[string]interface{}{
+				"id":      msg.ID,
+				"seq":     msg.Seq,
+				"client":  msg.SenderID,
+				"message": msg.Content,
+			})
+			if err != nil {
+				cr.loggy.Error("error in formatting SSE payload", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// MessageValidator checks an outbound message's content before it's
+// broadcast. Validators run in order; the first error wins and the
+// message is rejected.
+type MessageValidator interface {
+	Validate(content string) error
+}
+
+// MaxLengthValidator rejects messages longer than Max runes.
+type MaxLengthValidator struct {
+	Max int
+}
+
+func (v MaxLengthValidator) Validate(content string) error {
+	if utf8.RuneCountInString(content) > v.Max {
+		return fmt.Errorf("message exceeds maximum length of %d characters", v.Max)
+	}
+	return nil
+}
+
+// UTF8Validator rejects messages that aren't valid UTF-8.
+type UTF8Validator struct{}
+
+func (UTF8Validator) Validate(content string) error {
+	if !utf8.ValidString(content) {
+		return fmt.Errorf("message must be valid UTF-8")
+	}
+	return nil
+}
+
+// MessagePreprocessor rewrites a message's content before validation and
+// broadcast, e.g. to substitute profanity or expand emote shortcodes.
+type MessagePreprocessor interface {
+	Apply(content string) string
+}
+
+// SubstitutionPreprocessor replaces each key in Replacements with its
+// value, in map iteration order.
+type SubstitutionPreprocessor struct {
+	Replacements map[string]string
+}
+
+func (p SubstitutionPreprocessor) Apply(content string) string {
+	for from, to := range p.Replacements {
+		content = strings.ReplaceAll(content, from, to)
+	}
+	return content
+}